@@ -0,0 +1,166 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/pflag"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+var (
+	cacheEnabled    = pflag.Bool("cache.enabled", false, "enable JSON-RPC response caching, requires jsonrpc.config")
+	cacheBackend    = pflag.String("cache.backend", "memory", "response cache backend: memory or redis")
+	cacheMemorySize = pflag.Int("cache.memory-size", 10000, "max entries kept by the memory cache backend")
+	cacheRedisAddr  = pflag.String("cache.redis-addr", "127.0.0.1:6379", "redis address used by the redis cache backend")
+
+	respCache *responseCache
+)
+
+// responseCache stores a jsonrpc.go call's raw JSON-RPC result, keyed on its
+// (method, params) pair, and coalesces concurrent identical calls so only
+// one of them actually reaches an upstream.
+type responseCache struct {
+	store cacheStore
+	group singleflight.Group
+}
+
+func newResponseCache() *responseCache {
+	var store cacheStore
+	switch *cacheBackend {
+	case "redis":
+		store = newRedisCache(*cacheRedisAddr)
+	default:
+		store = newMemoryCache(*cacheMemorySize)
+	}
+	return &responseCache{store: store}
+}
+
+// getOrLoad returns the cached value for key if present, otherwise calls
+// load, caches its result for ttl (if ttl > 0 and the load succeeded), and
+// returns it. Concurrent calls for the same key share one load.
+func (c *responseCache) getOrLoad(ctx context.Context, key string, ttl time.Duration, load func() (jsonrpcResponse, error)) (jsonrpcResponse, error) {
+	if b, ok := c.store.Get(ctx, key); ok {
+		metricCacheHitsTotal.Inc()
+		var resp jsonrpcResponse
+		if err := json.Unmarshal(b, &resp); err == nil {
+			return resp, nil
+		}
+	}
+	metricCacheMissesTotal.Inc()
+
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		resp, err := load()
+		if err == nil && resp.Error == nil && ttl > 0 {
+			if b, marshalErr := json.Marshal(resp); marshalErr == nil {
+				c.store.Set(ctx, key, b, ttl)
+			}
+		}
+		return resp, err
+	})
+	if err != nil {
+		return jsonrpcResponse{}, err
+	}
+	return v.(jsonrpcResponse), nil
+}
+
+// cacheStore is the pluggable backend behind responseCache.
+type cacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// memoryCache is an in-process LRU, the default cache backend.
+type memoryCache struct {
+	mu       sync.Mutex
+	maxSize  int
+	ll       *list.List
+	elements map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryCache(maxSize int) *memoryCache {
+	return &memoryCache{
+		maxSize:  maxSize,
+		ll:       list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.elements[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.ll.Remove(elem)
+		delete(c.elements, key)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.elements[key]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*memoryCacheEntry).value = value
+		elem.Value.(*memoryCacheEntry).expiresAt = time.Now().Add(ttl)
+		return
+	}
+
+	elem := c.ll.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+	c.elements[key] = elem
+
+	for c.ll.Len() > c.maxSize {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.elements, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// redisCache backs responseCache with a shared Redis instance, useful when
+// running multiple proxy replicas that should share a cache.
+type redisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(addr string) *redisCache {
+	return &redisCache{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	b, err := c.client.Get(ctx, key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logger.Warnf("redis cache get error: %s", err)
+		}
+		return nil, false
+	}
+	return b, true
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		logger.Warnf("redis cache set error: %s", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCacheBypassedBlockTagSensitiveMethods(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		params string
+		want   bool
+	}{
+		{"eth_call latest bypasses", "eth_call", `[{"to":"0x1"},"latest"]`, true},
+		{"eth_call pending bypasses", "eth_call", `[{"to":"0x1"},"pending"]`, true},
+		{"eth_call pinned block does not bypass", "eth_call", `[{"to":"0x1"},"0x10"]`, false},
+		{"non block-tag method never bypasses", "eth_chainId", `[]`, false},
+		{"empty params does not bypass", "eth_call", `[]`, false},
+		{"malformed params does not bypass", "eth_call", `not-json`, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := cacheBypassed(tc.method, json.RawMessage(tc.params))
+			if got != tc.want {
+				t.Errorf("cacheBypassed(%q, %s) = %v, want %v", tc.method, tc.params, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheExpiresEntriesByTTL(t *testing.T) {
+	c := newMemoryCache(2)
+	ctx := context.Background()
+
+	c.Set(ctx, "a", []byte("1"), 20*time.Millisecond)
+	if v, ok := c.Get(ctx, "a"); !ok || string(v) != "1" {
+		t.Fatalf("expected fresh entry to be retrievable, got %q ok=%v", v, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Fatalf("expected entry past its ttl to be evicted on read")
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newMemoryCache(2)
+	ctx := context.Background()
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a") // touch a so b becomes the least recently used entry
+
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok := c.Get(ctx, "b"); ok {
+		t.Fatalf("expected least recently used entry b to be evicted")
+	}
+	if _, ok := c.Get(ctx, "a"); !ok {
+		t.Fatalf("expected recently used entry a to survive eviction")
+	}
+	if _, ok := c.Get(ctx, "c"); !ok {
+		t.Fatalf("expected newly inserted entry c to be present")
+	}
+}
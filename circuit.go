@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/spf13/pflag"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	breakerErrorRatio   = pflag.Float64("reverse.breaker-error-ratio", 0.5, "error ratio over reverse.breaker-window that trips a node's circuit breaker open")
+	breakerMinRequests  = pflag.Int64("reverse.breaker-min-requests", 10, "minimum requests within reverse.breaker-window before the error ratio is evaluated")
+	breakerWindow       = pflag.Duration("reverse.breaker-window", 30*time.Second, "window used to compute a node's error ratio; counts reset once it elapses, they do not slide")
+	breakerOpenDuration = pflag.Duration("reverse.breaker-open-duration", 30*time.Second, "how long a tripped breaker stays open before letting one half-open probe request through")
+)
+
+type circuitState int32
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker trips a node's selection open once its error ratio over a
+// fixed window of breakerWindow reaches breakerErrorRatio; the success and
+// failure counts are reset together when a window elapses rather than
+// sliding. It is independent of the simpler
+// consecutive-failure ejection in node.go. Its zero value is a closed breaker.
+type circuitBreaker struct {
+	name string // set to the owning node's URL for the breaker-transitions metric
+
+	state atomic.Int32
+
+	mu          sync.Mutex
+	windowStart time.Time
+	successes   int64
+	failures    int64
+	openedAt    time.Time
+}
+
+func (cb *circuitBreaker) recordResult(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cb.windowStart) > *breakerWindow {
+		cb.windowStart = now
+		cb.successes = 0
+		cb.failures = 0
+	}
+
+	if circuitState(cb.state.Load()) == circuitHalfOpen {
+		if success {
+			cb.closeLocked()
+		} else {
+			cb.openLocked(now)
+		}
+		return
+	}
+
+	if success {
+		cb.successes++
+	} else {
+		cb.failures++
+	}
+
+	if total := cb.successes + cb.failures; total >= *breakerMinRequests {
+		if ratio := float64(cb.failures) / float64(total); ratio >= *breakerErrorRatio {
+			cb.openLocked(now)
+		}
+	}
+}
+
+func (cb *circuitBreaker) openLocked(now time.Time) {
+	if circuitState(cb.state.Load()) != circuitOpen {
+		logger.Warnf("circuit breaker for %s tripped open", cb.name)
+		metricBreakerTransitionsTotal.WithLabelValues(cb.name, "open").Inc()
+	}
+	cb.state.Store(int32(circuitOpen))
+	cb.openedAt = now
+	metricBreakerState.WithLabelValues(cb.name).Set(float64(circuitOpen))
+}
+
+func (cb *circuitBreaker) closeLocked() {
+	logger.Infof("circuit breaker for %s closed", cb.name)
+	metricBreakerTransitionsTotal.WithLabelValues(cb.name, "closed").Inc()
+	cb.state.Store(int32(circuitClosed))
+	cb.successes = 0
+	cb.failures = 0
+	metricBreakerState.WithLabelValues(cb.name).Set(float64(circuitClosed))
+}
+
+// allow reports whether a node may currently be considered for selection,
+// without spending its one-shot open->half-open trial. Selectors use this
+// to build their candidate list, so merely appearing in that list — across
+// however many selection passes happen to run before the node is actually
+// dispatched to — never consumes the trial on its own.
+func (cb *circuitBreaker) allow() bool {
+	switch circuitState(cb.state.Load()) {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		return false
+	default: // circuitOpen
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		return time.Since(cb.openedAt) >= *breakerOpenDuration
+	}
+}
+
+// admit is allow's state-mutating counterpart. It must be called exactly
+// once, only on the node a Selector actually returns for dispatch: that's
+// the one moment a breaker that's been open for breakerOpenDuration flips
+// to half-open and lets a single trial request through. Its outcome (via
+// recordResult) decides whether the breaker closes or reopens; until then,
+// admit refuses every further call for this node.
+func (cb *circuitBreaker) admit() bool {
+	switch circuitState(cb.state.Load()) {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		return false
+	default: // circuitOpen
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		if time.Since(cb.openedAt) < *breakerOpenDuration {
+			return false
+		}
+		if cb.state.CompareAndSwap(int32(circuitOpen), int32(circuitHalfOpen)) {
+			metricBreakerTransitionsTotal.WithLabelValues(cb.name, "half_open").Inc()
+			metricBreakerState.WithLabelValues(cb.name).Set(float64(circuitHalfOpen))
+			return true
+		}
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain gives every test a non-nil logger, since circuitBreaker (like
+// most of this package) logs through the package-level logger var that's
+// otherwise only set up by main().
+func TestMain(m *testing.M) {
+	logger = NewSlogLogger(false)
+	os.Exit(m.Run())
+}
+
+// withBreakerFlags overrides the breaker pflag values for the duration of a
+// test and restores them afterwards.
+func withBreakerFlags(t *testing.T, ratio float64, minRequests int64, window, openDuration time.Duration) {
+	t.Helper()
+	prevRatio, prevMin, prevWindow, prevOpen := *breakerErrorRatio, *breakerMinRequests, *breakerWindow, *breakerOpenDuration
+	*breakerErrorRatio, *breakerMinRequests, *breakerWindow, *breakerOpenDuration = ratio, minRequests, window, openDuration
+	t.Cleanup(func() {
+		*breakerErrorRatio, *breakerMinRequests, *breakerWindow, *breakerOpenDuration = prevRatio, prevMin, prevWindow, prevOpen
+	})
+}
+
+func TestCircuitBreakerTripsOpenOnErrorRatio(t *testing.T) {
+	withBreakerFlags(t, 0.5, 4, time.Hour, time.Hour)
+
+	cb := &circuitBreaker{name: "test"}
+	cb.recordResult(true)
+	cb.recordResult(true)
+	if !cb.allow() {
+		t.Fatalf("breaker should still be closed below breakerMinRequests")
+	}
+
+	cb.recordResult(false)
+	cb.recordResult(false)
+	if cb.allow() {
+		t.Fatalf("breaker should be open once the error ratio crosses breakerErrorRatio")
+	}
+}
+
+// TestCircuitBreakerAllowIsReadOnly guards against the regression where
+// healthyNodes() called the state-mutating half-open transition
+// merely to build a candidate list, spending the one-shot trial on nodes
+// that were never actually dispatched to.
+func TestCircuitBreakerAllowIsReadOnly(t *testing.T) {
+	withBreakerFlags(t, 0.5, 1, time.Hour, 0)
+
+	cb := &circuitBreaker{name: "test"}
+	cb.recordResult(false) // trips open, breakerMinRequests=1
+
+	for i := 0; i < 5; i++ {
+		if !cb.allow() {
+			t.Fatalf("allow() should report the node eligible once breakerOpenDuration has elapsed")
+		}
+	}
+	if circuitState(cb.state.Load()) != circuitOpen {
+		t.Fatalf("allow() must not mutate breaker state, still expected circuitOpen")
+	}
+}
+
+func TestCircuitBreakerAdmitGrantsExactlyOneTrial(t *testing.T) {
+	withBreakerFlags(t, 0.5, 1, time.Hour, 0)
+
+	cb := &circuitBreaker{name: "test"}
+	cb.recordResult(false)
+
+	if !cb.admit() {
+		t.Fatalf("admit() should grant the first trial once breakerOpenDuration has elapsed")
+	}
+	if circuitState(cb.state.Load()) != circuitHalfOpen {
+		t.Fatalf("admit() should flip the breaker to half-open")
+	}
+	if cb.admit() {
+		t.Fatalf("admit() should refuse a second concurrent trial while half-open")
+	}
+}
+
+func TestCircuitBreakerHalfOpenClosesOnSuccess(t *testing.T) {
+	withBreakerFlags(t, 0.5, 1, time.Hour, 0)
+
+	cb := &circuitBreaker{name: "test"}
+	cb.recordResult(false)
+	if !cb.admit() {
+		t.Fatalf("expected admit() to grant the trial")
+	}
+
+	cb.recordResult(true)
+	if circuitState(cb.state.Load()) != circuitClosed {
+		t.Fatalf("a successful half-open trial should close the breaker")
+	}
+}
+
+func TestCircuitBreakerHalfOpenReopensOnFailure(t *testing.T) {
+	withBreakerFlags(t, 0.5, 1, time.Hour, 0)
+
+	cb := &circuitBreaker{name: "test"}
+	cb.recordResult(false)
+	if !cb.admit() {
+		t.Fatalf("expected admit() to grant the trial")
+	}
+
+	cb.recordResult(false)
+	if circuitState(cb.state.Load()) != circuitOpen {
+		t.Fatalf("a failed half-open trial should reopen the breaker")
+	}
+}
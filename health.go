@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	healthCheckInterval = pflag.Duration("health.interval", 15*time.Second, "interval between active health probes, set to 0 to disable")
+	healthCheckTimeout  = pflag.Duration("health.timeout", 5*time.Second, "timeout for a single active health probe")
+	healthCheckProbe    = pflag.String("health.probe", "http", "active health probe kind: http (GET /) or jsonrpc (health.jsonrpc-method)")
+	healthCheckMethod   = pflag.String("health.jsonrpc-method", "eth_blockNumber", "json-rpc method used to probe nodes when health.probe=jsonrpc")
+
+	healthFailThreshold = pflag.Uint64("health.fail-threshold", 3, "consecutive 5xx/429/timeout responses before a node is passively ejected")
+	healthCooldown      = pflag.Duration("health.cooldown", 30*time.Second, "how long an ejected node stays unhealthy before it's actively re-probed")
+)
+
+// runHealthChecker periodically probes every node in nodes so that
+// selectors can skip upstreams that are down before a client ever sees an
+// error. It's started once for ReverseProxyNodes and, when configured,
+// again for WSProxyNodes.
+func runHealthChecker(ctx context.Context, nodes []*ReverseProxyNode) {
+	if *healthCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(*healthCheckInterval)
+	defer ticker.Stop()
+
+	// probe once up front so nodes aren't assumed healthy indefinitely
+	// before the first tick.
+	probeAllNodes(nodes)
+
+	for {
+		select {
+		case <-ticker.C:
+			probeAllNodes(nodes)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func probeAllNodes(nodes []*ReverseProxyNode) {
+	for _, node := range nodes {
+		if node.inCooldown() {
+			continue
+		}
+		go probeNode(node)
+	}
+}
+
+func probeNode(node *ReverseProxyNode) {
+	ctx, cancel := context.WithTimeout(context.Background(), *healthCheckTimeout)
+	defer cancel()
+
+	var err error
+	if *healthCheckProbe == "jsonrpc" {
+		err = probeJSONRPC(ctx, node)
+	} else {
+		err = probeHTTP(ctx, node)
+	}
+
+	if err != nil {
+		logger.Debugf("health probe failed for node %s: %s", node.URL, err)
+		node.markUnhealthy()
+		return
+	}
+
+	node.markHealthy()
+}
+
+// httpProbeURL returns node's URL with a ws/wss scheme swapped for
+// http/https, since --reverse.ws-nodes entries are ws(s):// URLs but the
+// active health checker probes with a plain http.Client.
+func httpProbeURL(nodeURL *url.URL) string {
+	switch nodeURL.Scheme {
+	case "ws":
+		probeURL := *nodeURL
+		probeURL.Scheme = "http"
+		return probeURL.String()
+	case "wss":
+		probeURL := *nodeURL
+		probeURL.Scheme = "https"
+		return probeURL.String()
+	default:
+		return nodeURL.String()
+	}
+}
+
+func probeHTTP(ctx context.Context, node *ReverseProxyNode) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpProbeURL(node.URL), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unhealthy status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func probeJSONRPC(ctx context.Context, node *ReverseProxyNode) error {
+	payload := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q}`, *healthCheckMethod)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpProbeURL(node.URL), strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json;charset=utf8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	jsonResp := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&jsonResp); err != nil {
+		return err
+	}
+	if jsonErr, ok := jsonResp["error"]; ok {
+		return fmt.Errorf("json-rpc error response: %v", jsonErr)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var jsonrpcConfigPath = pflag.String("jsonrpc.config", "", "path to a JSON-RPC method policy config (YAML or JSON); enables JSON-RPC aware routing when set")
+
+var jsonrpcCfg *jsonrpcConfig
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+// jsonrpcMethodPolicy configures how a single JSON-RPC method is routed.
+// It's looked up by method name from jsonrpcConfig.Methods.
+type jsonrpcMethodPolicy struct {
+	Allow       bool    `yaml:"allow" json:"allow"`
+	Deny        bool    `yaml:"deny" json:"deny"`
+	RateLimit   float64 `yaml:"rateLimit" json:"rateLimit"`     // requests per second, 0 disables limiting
+	RateBurst   int     `yaml:"rateBurst" json:"rateBurst"`     // defaults to 1 when RateLimit > 0
+	UpstreamTag string  `yaml:"upstreamTag" json:"upstreamTag"` // restrict to nodes carrying this tag
+	CacheTTL    string  `yaml:"cacheTTL" json:"cacheTTL"`       // e.g. "1s", "1h"; empty disables caching for this method
+}
+
+// jsonrpcConfig is the --jsonrpc.config document. It's parsed with yaml.v3,
+// which also accepts plain JSON, so either format works unmodified.
+type jsonrpcConfig struct {
+	DefaultAllow bool                           `yaml:"defaultAllow" json:"defaultAllow"`
+	Methods      map[string]jsonrpcMethodPolicy `yaml:"methods" json:"methods"`
+
+	limiters  map[string]*rate.Limiter
+	cacheTTLs map[string]time.Duration
+}
+
+func loadJSONRPCConfig(path string) (*jsonrpcConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &jsonrpcConfig{DefaultAllow: true}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+
+	cfg.limiters = make(map[string]*rate.Limiter, len(cfg.Methods))
+	cfg.cacheTTLs = make(map[string]time.Duration, len(cfg.Methods))
+	for method, policy := range cfg.Methods {
+		if policy.RateLimit > 0 {
+			burst := policy.RateBurst
+			if burst <= 0 {
+				burst = 1
+			}
+			cfg.limiters[method] = rate.NewLimiter(rate.Limit(policy.RateLimit), burst)
+		}
+		if policy.CacheTTL != "" {
+			ttl, err := time.ParseDuration(policy.CacheTTL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cacheTTL for method %q: %w", method, err)
+			}
+			cfg.cacheTTLs[method] = ttl
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *jsonrpcConfig) methodPolicy(method string) jsonrpcMethodPolicy {
+	return cfg.Methods[method]
+}
+
+// allowed reports whether method may be dispatched at all, independent of
+// rate limiting.
+func (cfg *jsonrpcConfig) allowed(method string, policy jsonrpcMethodPolicy) bool {
+	if policy.Deny {
+		return false
+	}
+	if policy.Allow {
+		return true
+	}
+	return cfg.DefaultAllow
+}
+
+// parseNodeSpec extends parseNodeWeight with the "#tag1,tag2" suffix used
+// for per-method upstream affinity, e.g. "https://x@3#archive,full".
+func parseNodeSpec(raw string) (nodeURL string, weight uint32, tags []string) {
+	base := raw
+	if idx := strings.Index(raw, "#"); idx != -1 {
+		base = raw[:idx]
+		for _, tag := range strings.Split(raw[idx+1:], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	nodeURL, weight = parseNodeWeight(base)
+	return
+}
+
+// tagSelectors caches a Selector per upstream-affinity tag so each tag's
+// subset of nodes keeps its own round-robin/least-conn/etc. state.
+var (
+	tagSelectorsMu sync.Mutex
+	tagSelectors   = map[string]Selector{}
+)
+
+func selectorForTag(tag string) Selector {
+	if tag == "" {
+		return nodeSelector
+	}
+
+	tagSelectorsMu.Lock()
+	defer tagSelectorsMu.Unlock()
+
+	if sel, ok := tagSelectors[tag]; ok {
+		return sel
+	}
+
+	var tagged []*ReverseProxyNode
+	for _, node := range ReverseProxyNodes {
+		if node.hasTag(tag) {
+			tagged = append(tagged, node)
+		}
+	}
+	if len(tagged) == 0 {
+		logger.Warnf("jsonrpc: no nodes tagged %q, falling back to all nodes", tag)
+		tagged = ReverseProxyNodes
+	}
+
+	sel := buildSelector(*reversePolicy, tagged)
+	tagSelectors[tag] = sel
+	return sel
+}
+
+// serveJSONRPC parses the request body as a single or batch JSON-RPC call,
+// dispatches each sub-call per the configured method policy, and merges the
+// results back into one response preserving the original id ordering.
+func serveJSONRPC(writer http.ResponseWriter, request *http.Request) {
+	b, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+
+	trimmed := bytes.TrimSpace(b)
+	batch := len(trimmed) > 0 && trimmed[0] == '['
+
+	var calls []jsonrpcRequest
+	if batch {
+		if err := json.Unmarshal(trimmed, &calls); err != nil {
+			writeJSONRPCParseError(writer, err)
+			return
+		}
+	} else {
+		var call jsonrpcRequest
+		if err := json.Unmarshal(trimmed, &call); err != nil {
+			writeJSONRPCParseError(writer, err)
+			return
+		}
+		calls = []jsonrpcRequest{call}
+	}
+
+	responses := make([]jsonrpcResponse, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call jsonrpcRequest) {
+			defer wg.Done()
+			responses[i] = dispatchCall(request.Context(), call)
+		}(i, call)
+	}
+	wg.Wait()
+
+	writer.Header().Set("Content-Type", "application/json;charset=utf8")
+	if batch {
+		_ = json.NewEncoder(writer).Encode(responses)
+	} else {
+		_ = json.NewEncoder(writer).Encode(responses[0])
+	}
+}
+
+func dispatchCall(ctx context.Context, call jsonrpcRequest) jsonrpcResponse {
+	metricJSONRPCMethodTotal.WithLabelValues(call.Method).Inc()
+
+	policy := jsonrpcCfg.methodPolicy(call.Method)
+	if !jsonrpcCfg.allowed(call.Method, policy) {
+		return jsonrpcErrorResponse(call.ID, -32601, fmt.Sprintf("method %q is not allowed", call.Method))
+	}
+	if limiter := jsonrpcCfg.limiters[call.Method]; limiter != nil && !limiter.Allow() {
+		return jsonrpcErrorResponse(call.ID, -32005, fmt.Sprintf("rate limit exceeded for method %q", call.Method))
+	}
+
+	var resp jsonrpcResponse
+	var haveResp bool
+	if ttl := jsonrpcCfg.cacheTTLs[call.Method]; respCache != nil && ttl > 0 && !cacheBypassed(call.Method, call.Params) {
+		cached, err := respCache.getOrLoad(ctx, cacheKey(call), ttl, func() (jsonrpcResponse, error) {
+			node := selectorForTag(policy.UpstreamTag).Next(nil)
+			return callUpstream(ctx, node, call), nil
+		})
+		if err == nil {
+			cached.ID = call.ID
+			resp = cached
+			haveResp = true
+		}
+	}
+	if !haveResp {
+		node := selectorForTag(policy.UpstreamTag).Next(nil)
+		resp = callUpstream(ctx, node, call)
+	}
+
+	return resp
+}
+
+// blockTagSensitiveMethods take a block tag as their final parameter; a
+// "latest"/"pending" tag means the result must not be served from cache.
+var blockTagSensitiveMethods = map[string]bool{
+	"eth_call":                true,
+	"eth_getBalance":          true,
+	"eth_getCode":             true,
+	"eth_getStorageAt":        true,
+	"eth_getTransactionCount": true,
+}
+
+func cacheBypassed(method string, params json.RawMessage) bool {
+	if !blockTagSensitiveMethods[method] {
+		return false
+	}
+
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return false
+	}
+
+	var tag string
+	if err := json.Unmarshal(args[len(args)-1], &tag); err != nil {
+		return false
+	}
+	return tag == "latest" || tag == "pending"
+}
+
+func cacheKey(call jsonrpcRequest) string {
+	return call.Method + ":" + string(call.Params)
+}
+
+// callUpstream sends a single JSON-RPC call to node and reuses
+// ReverseProxyNode.ModifyResponse so the call counts toward the same
+// per-node metrics and passive-ejection tracking as proxied traffic.
+func callUpstream(ctx context.Context, node *ReverseProxyNode, call jsonrpcRequest) jsonrpcResponse {
+	payload, err := json.Marshal(call)
+	if err != nil {
+		return jsonrpcErrorResponse(call.ID, -32603, fmt.Sprintf("internal error: %s", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL.String(), bytes.NewReader(payload))
+	if err != nil {
+		return jsonrpcErrorResponse(call.ID, -32603, fmt.Sprintf("internal error: %s", err))
+	}
+	req.Header.Set("Content-Type", "application/json;charset=utf8")
+
+	resp, err := node.Proxy.Transport.RoundTrip(req)
+	if err != nil {
+		failingNode(err, node).recordFailure()
+		return jsonrpcErrorResponse(call.ID, -32000, fmt.Sprintf("upstream error: %s", err))
+	}
+
+	if err := respondingNode(resp, node).ModifyResponse(resp); err != nil {
+		return jsonrpcErrorResponse(call.ID, -32603, fmt.Sprintf("internal error: %s", err))
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return jsonrpcErrorResponse(call.ID, -32603, fmt.Sprintf("internal error: %s", err))
+	}
+
+	var parsed jsonrpcResponse
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return jsonrpcErrorResponse(call.ID, -32700, fmt.Sprintf("parse error: %s", err))
+	}
+	// the upstream may echo back a different id encoding; always return the
+	// id the client sent us.
+	parsed.ID = call.ID
+	return parsed
+}
+
+// jsonrpcErrorResponse builds a locally-synthesized JSON-RPC error response
+// (policy denial, rate limiting, parse/transport failures) and owns counting
+// it toward jsonrpc_errors_total; errors forwarded verbatim from an upstream
+// are counted once, by ReverseProxyNode.ModifyResponse, when the raw body is
+// scanned for an `error` field.
+func jsonrpcErrorResponse(id json.RawMessage, code int, message string) jsonrpcResponse {
+	metricJSONRPCErrorsTotal.WithLabelValues(strconv.Itoa(code)).Inc()
+	return jsonrpcResponse{JSONRPC: "2.0", ID: id, Error: &jsonrpcError{Code: code, Message: message}}
+}
+
+func writeJSONRPCParseError(writer http.ResponseWriter, err error) {
+	logger.Warnf("jsonrpc: failed to parse request body: %s", err)
+	writer.Header().Set("Content-Type", "application/json;charset=utf8")
+	_ = json.NewEncoder(writer).Encode(jsonrpcErrorResponse(nil, -32700, "parse error"))
+}
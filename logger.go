@@ -2,12 +2,12 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 )
 
 var (
-	_ Logger = (*ConsoleLogger)(nil)
+	_ Logger = (*SlogLogger)(nil)
 )
 
 type Logger interface {
@@ -16,36 +16,46 @@ type Logger interface {
 	Warnf(format string, args ...any)
 	Errorf(format string, args ...any)
 	Fatalf(format string, args ...any)
-}
 
-type ConsoleLogger struct {
-	std   *log.Logger
-	debug bool
+	// WithRequestID returns a derived Logger that tags every record with
+	// requestID, so a request's logs can be correlated across the proxy.
+	WithRequestID(requestID string) Logger
 }
 
-func NewConsoleLogger(debug bool) Logger {
-	return &ConsoleLogger{std: log.New(os.Stdout, "", log.LstdFlags), debug: debug}
+type SlogLogger struct {
+	std *slog.Logger
 }
 
-func (c *ConsoleLogger) Debugf(format string, args ...any) {
-	if c.debug {
-		_ = c.std.Output(2, fmt.Sprintf("[DEBUG] "+format, args...))
+func NewSlogLogger(debug bool) Logger {
+	level := slog.LevelInfo
+	if debug {
+		level = slog.LevelDebug
 	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	return &SlogLogger{std: slog.New(handler)}
+}
+
+func (l *SlogLogger) Debugf(format string, args ...any) {
+	l.std.Debug(fmt.Sprintf(format, args...))
 }
 
-func (c *ConsoleLogger) Infof(format string, args ...any) {
-	_ = c.std.Output(2, fmt.Sprintf("[INFO] "+format, args...))
+func (l *SlogLogger) Infof(format string, args ...any) {
+	l.std.Info(fmt.Sprintf(format, args...))
 }
 
-func (c *ConsoleLogger) Warnf(format string, args ...any) {
-	_ = c.std.Output(2, fmt.Sprintf("[WARN] "+format, args...))
+func (l *SlogLogger) Warnf(format string, args ...any) {
+	l.std.Warn(fmt.Sprintf(format, args...))
 }
 
-func (c *ConsoleLogger) Errorf(format string, args ...any) {
-	_ = c.std.Output(2, fmt.Sprintf("[ERROR] "+format, args...))
+func (l *SlogLogger) Errorf(format string, args ...any) {
+	l.std.Error(fmt.Sprintf(format, args...))
 }
 
-func (c *ConsoleLogger) Fatalf(format string, args ...any) {
-	_ = c.std.Output(2, fmt.Sprintf("[FATAL] "+format, args...))
+func (l *SlogLogger) Fatalf(format string, args ...any) {
+	l.std.Error(fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
+
+func (l *SlogLogger) WithRequestID(requestID string) Logger {
+	return &SlogLogger{std: l.std.With("request_id", requestID)}
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/pflag"
 	"io"
 	"net/http"
@@ -13,7 +16,6 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"sync/atomic"
 	"time"
 )
 
@@ -33,22 +35,21 @@ var (
 
 	nodeHealthProxy = pflag.Bool("node-health-proxy", false, "enable health check proxy, if enabled, the `reverse.nodes` MUST only one node, it will proxy `eth_syncing`")
 
-	printMetricsInterval = pflag.Duration("metrics.interval", time.Minute*5, "print metrics interval, set to 0 to disable")
-	debug                = pflag.Bool("debug", false, "debug mode")
+	debug = pflag.Bool("debug", false, "debug mode")
 )
 
 var (
 	logger Logger
 
 	ReverseProxyNodes []*ReverseProxyNode
-	nodesIndex        = &atomic.Uint64{}
+	nodeSelector      Selector
 )
 
 func main() {
 	pflag.Parse()
 
 	// setup logger
-	logger = NewConsoleLogger(*debug)
+	logger = NewSlogLogger(*debug)
 
 	// setup reverse proxy nodes
 	if len(*nodes) == 0 {
@@ -61,19 +62,53 @@ func main() {
 
 	ReverseProxyNodes = make([]*ReverseProxyNode, len(*nodes))
 	for i, node := range *nodes {
-		nodeURL, err := url.Parse(node)
+		nodeStr, weight, tags := parseNodeSpec(node)
+		nodeURL, err := url.Parse(nodeStr)
 		if err != nil {
-			logger.Fatalf("Can't parse node url: %s", node)
+			logger.Fatalf("Can't parse node url: %s", nodeStr)
 		}
 		ReverseProxyNodes[i] = buildNode(nodeURL)
+		ReverseProxyNodes[i].Weight = weight
+		ReverseProxyNodes[i].Tags = tags
+	}
+
+	nodeSelector = buildSelector(*reversePolicy, ReverseProxyNodes)
+
+	// setup websocket upstream nodes, if configured
+	setupWSNodes()
+
+	// setup jsonrpc aware routing, if configured
+	if *jsonrpcConfigPath != "" {
+		cfg, err := loadJSONRPCConfig(*jsonrpcConfigPath)
+		if err != nil {
+			logger.Fatalf("Can't load jsonrpc config: %s", err)
+		}
+		jsonrpcCfg = cfg
+	}
+
+	// setup response caching, if enabled
+	if *cacheEnabled {
+		respCache = newResponseCache()
 	}
 
 	// start http server
 
 	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		node := nextNode()
+		if jsonrpcCfg != nil {
+			serveJSONRPC(writer, request)
+			return
+		}
+		node := nodeSelector.Next(request)
 		node.Proxy.ServeHTTP(writer, request)
 	})
+	http.HandleFunc("/ws", func(writer http.ResponseWriter, request *http.Request) {
+		if len(WSProxyNodes) == 0 {
+			http.Error(writer, "websocket proxying is not configured, set --reverse.ws-nodes", http.StatusNotImplemented)
+			return
+		}
+		serveWS(writer, request)
+	})
+	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
 		if *nodeHealthProxy {
 			node := ReverseProxyNodes[0]
@@ -143,8 +178,11 @@ func main() {
 		}
 	}()
 
-	// start print metrics
-	go runPrintNodeMetrics(ctx)
+	// start health checking
+	go runHealthChecker(ctx, ReverseProxyNodes)
+	if len(WSProxyNodes) > 0 {
+		go runHealthChecker(ctx, WSProxyNodes)
+	}
 
 	logger.Infof("proxy server started at http://%s:%d", *host, *port)
 	<-ctx.Done()
@@ -167,48 +205,55 @@ func buildNode(target *url.URL) *ReverseProxyNode {
 		// disable set x-forwarded-for
 		req.Header["X-Forwarded-For"] = nil
 		req.Host = target.Host
+
+		// propagate a correlation id to the upstream, generating one if the
+		// client didn't already supply one, so proxy and upstream logs for
+		// the same request can be tied together.
+		requestID := req.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		req.Header.Set("X-Request-ID", requestID)
 	}
 
 	node := &ReverseProxyNode{
 		URL:   target,
 		Proxy: proxy,
 	}
+	node.Healthy.Store(true)
+	node.Breaker.name = target.String()
+	metricNodeHealthy.WithLabelValues(target.String()).Set(1)
 	proxy.ModifyResponse = func(response *http.Response) error {
-		return node.ModifyResponse(response)
+		return respondingNode(response, node).ModifyResponse(response)
+	}
+	proxy.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, err error) {
+		failedNode := failingNode(err, node)
+		requestLogger(request).Warnf("transport error calling node %s: %s", failedNode.URL, err)
+		failedNode.recordFailure()
+		writer.WriteHeader(http.StatusBadGateway)
+	}
+	proxy.Transport = &retryTransport{
+		node:      node,
+		transport: &instrumentedTransport{node: node, transport: http.DefaultTransport},
 	}
 
 	return node
 }
 
-func nextNode() *ReverseProxyNode {
-	nextIndex := nodesIndex.Add(1)
-	nextIndex = nextIndex % uint64(len(ReverseProxyNodes))
-	logger.Debugf("round robin: next %d", nextIndex)
-	return ReverseProxyNodes[nextIndex]
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
 
-func runPrintNodeMetrics(ctx context.Context) {
-	interval := *printMetricsInterval
-	if interval == 0 {
-		return
+// requestLogger derives a Logger tagged with request's correlation id, or
+// the base logger if the request has none yet.
+func requestLogger(request *http.Request) Logger {
+	if request == nil {
+		return logger
 	}
-
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			logger.Infof("==============================metrics start==============================")
-			for _, node := range ReverseProxyNodes {
-				logger.Infof("node %s: calls %d, 2xx %d, 4xx %d, 5xx %d",
-					node.URL,
-					atomic.LoadUint64(&node.Calls), atomic.LoadUint64(&node.Calls2XX),
-					atomic.LoadUint64(&node.Calls4XX), atomic.LoadUint64(&node.Calls5XX),
-				)
-			}
-		case <-ctx.Done():
-			return
-		}
+	if requestID := request.Header.Get("X-Request-ID"); requestID != "" {
+		return logger.WithRequestID(requestID)
 	}
+	return logger
 }
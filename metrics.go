@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricNodeCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reverse_proxy_node_calls_total",
+		Help: "Total calls sent to each upstream node.",
+	}, []string{"node"})
+
+	metricNodeStatusTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reverse_proxy_node_status_total",
+		Help: "Upstream responses per node, bucketed by status class.",
+	}, []string{"node", "class"})
+
+	metricJSONRPCMethodTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reverse_proxy_jsonrpc_method_total",
+		Help: "JSON-RPC calls dispatched, by method.",
+	}, []string{"method"})
+
+	metricJSONRPCErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jsonrpc_errors_total",
+		Help: "JSON-RPC error responses, by error code.",
+	}, []string{"code"})
+
+	metricResponseLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reverse_proxy_response_latency_seconds",
+		Help:    "Upstream response latency, by node.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"node"})
+
+	metricResponseBodyBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reverse_proxy_response_body_bytes",
+		Help:    "Upstream response body size, by node.",
+		Buckets: prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"node"})
+
+	metricRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reverse_proxy_retries_total",
+		Help: "Retries issued after a failed attempt, labeled by the node that failed.",
+	}, []string{"node"})
+
+	metricCacheHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reverse_proxy_cache_hits_total",
+		Help: "Response cache hits.",
+	})
+	metricCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reverse_proxy_cache_misses_total",
+		Help: "Response cache misses.",
+	})
+
+	metricBreakerTransitionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "reverse_proxy_breaker_transitions_total",
+		Help: "Circuit breaker state transitions, by node and the state it entered.",
+	}, []string{"node", "state"})
+
+	metricNodeHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reverse_proxy_node_healthy",
+		Help: "Whether a node is currently considered healthy (1) or ejected (0).",
+	}, []string{"node"})
+
+	metricBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "reverse_proxy_breaker_state",
+		Help: "Current circuit breaker state per node: 0=closed, 1=open, 2=half_open.",
+	}, []string{"node"})
+)
+
+func statusClass(statusCode int) string {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return "2xx"
+	case statusCode >= 400 && statusCode < 500:
+		return "4xx"
+	case statusCode >= 500 && statusCode < 600:
+		return "5xx"
+	default:
+		return "other"
+	}
+}
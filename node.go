@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"encoding/json"
 	"github.com/antchfx/jsonquery"
 	"io"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"sync/atomic"
+	"time"
 )
 
 type ReverseProxyNode struct {
@@ -21,18 +23,48 @@ type ReverseProxyNode struct {
 	Calls2XX uint64
 	Calls4XX uint64
 	Calls5XX uint64
+
+	// Healthy reflects the node's current health, as tracked by the active
+	// health checker and the passive ejection logic below.
+	Healthy          atomic.Bool
+	consecutiveFails atomic.Uint64
+	unhealthyUntil   atomic.Int64 // unix nano, 0 while healthy
+
+	// Weight is used by the weighted Selector; it defaults to 1.
+	Weight uint32
+
+	// Tags are used by the JSON-RPC middleware's per-method upstream
+	// affinity, e.g. restricting eth_getLogs to archive-tagged nodes.
+	Tags []string
+
+	// InFlight and latencyEWMA feed the least-conn and least-latency
+	// selectors, updated by the instrumentedTransport wrapping Proxy.
+	InFlight    atomic.Int64
+	latencyEWMA atomic.Int64 // nanoseconds, 0 until the first response
+
+	// Breaker short-circuits selection once this node's error ratio gets
+	// too high, independent of the consecutive-failure ejection above.
+	Breaker circuitBreaker
 }
 
 func (node *ReverseProxyNode) ModifyResponse(r *http.Response) error {
 	atomic.AddUint64(&node.Calls, 1)
+	nodeLabel := node.URL.String()
+	metricNodeCallsTotal.WithLabelValues(nodeLabel).Inc()
 	statusCode := r.StatusCode
 	defer func() {
+		metricNodeStatusTotal.WithLabelValues(nodeLabel, statusClass(statusCode)).Inc()
 		if statusCode >= 200 && statusCode < 300 {
 			atomic.AddUint64(&node.Calls2XX, 1)
+			node.recordSuccess()
 		} else if statusCode >= 400 && statusCode < 500 {
 			atomic.AddUint64(&node.Calls4XX, 1)
+			if statusCode == http.StatusTooManyRequests {
+				node.recordFailure()
+			}
 		} else if statusCode >= 500 && statusCode < 600 {
 			atomic.AddUint64(&node.Calls5XX, 1)
+			node.recordFailure()
 		}
 	}()
 
@@ -41,6 +73,7 @@ func (node *ReverseProxyNode) ModifyResponse(r *http.Response) error {
 		return err
 	}
 	defer r.Body.Close()
+	metricResponseBodyBytes.WithLabelValues(nodeLabel).Observe(float64(len(b)))
 
 	ub, err := tryDecompressResponse(r, b)
 	if err == nil {
@@ -48,8 +81,9 @@ func (node *ReverseProxyNode) ModifyResponse(r *http.Response) error {
 		if err == nil {
 			errorNode := jsonquery.FindOne(doc, "//error")
 			if errorNode != nil {
-				logger.Warnf("detect error from node: %s, content: %s", node.URL, errorNode.Value())
+				requestLogger(r.Request).Warnf("detect error from node: %s, content: %s", node.URL, errorNode.Value())
 				statusCode = 429 // if the response is invalid, force to return 429
+				metricJSONRPCErrorsTotal.WithLabelValues(strconv.Itoa(jsonrpcErrorCode(ub))).Inc()
 			}
 		} else {
 			logger.Warnf("parse response from node %s error: %s", node.URL, err)
@@ -63,6 +97,119 @@ func (node *ReverseProxyNode) ModifyResponse(r *http.Response) error {
 	return nil
 }
 
+// jsonrpcErrorCode best-effort extracts the "error.code" field from a
+// JSON-RPC response body, for the jsonrpc_errors_total metric.
+func jsonrpcErrorCode(body []byte) int {
+	var peek struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &peek); err != nil {
+		return 0
+	}
+	return peek.Error.Code
+}
+
+// recordFailure counts a passive failure (5xx, 429 or transport error) and
+// ejects the node once healthFailThreshold consecutive failures are seen.
+func (node *ReverseProxyNode) recordFailure() {
+	node.Breaker.recordResult(false)
+	fails := node.consecutiveFails.Add(1)
+	if fails >= *healthFailThreshold {
+		node.markUnhealthy()
+	}
+}
+
+func (node *ReverseProxyNode) recordSuccess() {
+	node.Breaker.recordResult(true)
+	node.consecutiveFails.Store(0)
+}
+
+// markUnhealthy ejects the node for healthCooldown, after which the active
+// health checker is allowed to probe it again.
+func (node *ReverseProxyNode) markUnhealthy() {
+	if node.Healthy.CompareAndSwap(true, false) {
+		logger.Warnf("node %s ejected: marked unhealthy", node.URL)
+	}
+	node.unhealthyUntil.Store(time.Now().Add(*healthCooldown).UnixNano())
+	metricNodeHealthy.WithLabelValues(node.URL.String()).Set(0)
+}
+
+func (node *ReverseProxyNode) markHealthy() {
+	if node.Healthy.CompareAndSwap(false, true) {
+		logger.Infof("node %s recovered: marked healthy", node.URL)
+	}
+	node.consecutiveFails.Store(0)
+	node.unhealthyUntil.Store(0)
+	metricNodeHealthy.WithLabelValues(node.URL.String()).Set(1)
+}
+
+// inCooldown reports whether the node was recently ejected and shouldn't be
+// actively re-probed yet.
+func (node *ReverseProxyNode) inCooldown() bool {
+	until := node.unhealthyUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// hasTag reports whether the node was tagged with tag via the "#tag1,tag2"
+// suffix on its --reverse.nodes entry.
+func (node *ReverseProxyNode) hasTag(tag string) bool {
+	for _, t := range node.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLatency folds d into the node's response-time EWMA, used by the
+// least-latency Selector.
+func (node *ReverseProxyNode) recordLatency(d time.Duration) {
+	const alpha = 0.2
+	for {
+		old := node.latencyEWMA.Load()
+		sample := float64(d.Nanoseconds())
+		next := sample
+		if old != 0 {
+			next = alpha*sample + (1-alpha)*float64(old)
+		}
+		if node.latencyEWMA.CompareAndSwap(old, int64(next)) {
+			return
+		}
+	}
+}
+
+// Latency returns the node's current response-time EWMA.
+func (node *ReverseProxyNode) Latency() time.Duration {
+	return time.Duration(node.latencyEWMA.Load())
+}
+
+// instrumentedTransport wraps a node's RoundTripper to track in-flight
+// request counts and response latency for the least-conn/least-latency
+// selectors.
+type instrumentedTransport struct {
+	node      *ReverseProxyNode
+	transport http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.node.InFlight.Add(1)
+	defer t.node.InFlight.Add(-1)
+
+	start := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err == nil {
+		// a fast failure (connection refused, DNS error) would otherwise
+		// pull the EWMA down and make a broken node look artificially fast
+		// to the least-latency selector.
+		t.node.recordLatency(elapsed)
+	}
+	metricResponseLatencySeconds.WithLabelValues(t.node.URL.String()).Observe(elapsed.Seconds())
+	return resp, err
+}
+
 func tryDecompressResponse(r *http.Response, b []byte) ([]byte, error) {
 	if r.Header.Get("Content-Encoding") == "gzip" {
 		ub, err := gzip.NewReader(io.NopCloser(bytes.NewReader(b)))
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/antchfx/jsonquery"
+	"github.com/spf13/pflag"
+	"io"
+	"net/http"
+	"time"
+)
+
+// respondingNodeKey tags a per-attempt request's context with the node that
+// attempt is actually being sent to, so ModifyResponse/ErrorHandler (which
+// are bound to whichever node was first selected) can attribute the
+// response to the node that really produced it.
+type respondingNodeKey struct{}
+
+func withRespondingNode(req *http.Request, node *ReverseProxyNode) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), respondingNodeKey{}, node))
+}
+
+// respondingNode returns the node tagged on resp's request by
+// withRespondingNode, or fallback if resp carries no such tag.
+func respondingNode(resp *http.Response, fallback *ReverseProxyNode) *ReverseProxyNode {
+	if resp != nil && resp.Request != nil {
+		if node, ok := resp.Request.Context().Value(respondingNodeKey{}).(*ReverseProxyNode); ok {
+			return node
+		}
+	}
+	return fallback
+}
+
+// roundTripError wraps a RoundTrip failure with the node that actually
+// attempted the call, so ErrorHandler can record the failure against it
+// instead of whichever node was first selected.
+type roundTripError struct {
+	node *ReverseProxyNode
+	err  error
+}
+
+func (e *roundTripError) Error() string { return e.err.Error() }
+func (e *roundTripError) Unwrap() error { return e.err }
+
+// failingNode returns the node a RoundTrip error should be attributed to,
+// unwrapping a roundTripError if present, or fallback otherwise.
+func failingNode(err error, fallback *ReverseProxyNode) *ReverseProxyNode {
+	var rtErr *roundTripError
+	if errors.As(err, &rtErr) {
+		return rtErr.node
+	}
+	return fallback
+}
+
+// retargetNode rewrites req's scheme and host so it addresses next instead
+// of whichever node req's URL was last rewritten for, since retrying or
+// hedging only swaps which node's RoundTripper handles the call.
+func retargetNode(req *http.Request, next *ReverseProxyNode) {
+	req.URL.Scheme = next.URL.Scheme
+	req.URL.Host = next.URL.Host
+	req.Host = next.URL.Host
+}
+
+var (
+	maxRetries = pflag.Int("reverse.max-retries", 2, "max retries against a different healthy upstream on 5xx/429/network error/json-rpc error")
+	hedgeAfter = pflag.Duration("reverse.hedge-after", 0, "if > 0, also dispatch the request to a second upstream once this long has passed without a response, and use whichever completes first")
+)
+
+type retryBudgetKey struct{}
+
+func retriesRemaining(req *http.Request) int {
+	if v, ok := req.Context().Value(retryBudgetKey{}).(int); ok {
+		return v
+	}
+	return *maxRetries
+}
+
+func withRetriesRemaining(req *http.Request, remaining int) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryBudgetKey{}, remaining))
+}
+
+// retryTransport wraps a node's instrumentedTransport so that a failed
+// attempt is transparently retried against a different healthy node, and
+// optionally hedged by racing a second node after hedgeAfter.
+type retryTransport struct {
+	node      *ReverseProxyNode
+	transport http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var bodyBytes []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		bodyBytes = b
+	}
+
+	budget := retriesRemaining(req)
+	resp, err := t.hedgedAttempt(req, bodyBytes)
+	if budget <= 0 || !shouldRetry(resp, err) {
+		return resp, err
+	}
+
+	next := pickDifferentNode(t.node)
+	if next == nil {
+		return resp, err
+	}
+
+	requestLogger(req).Warnf("retrying request against node %s after failure from %s", next.URL, t.node.URL)
+	metricRetriesTotal.WithLabelValues(t.node.URL.String()).Inc()
+	drainResponse(resp)
+
+	retryReq := cloneRequestWithBody(req, req.Context(), bodyBytes)
+	retryReq = withRetriesRemaining(retryReq, budget-1)
+	retargetNode(retryReq, next)
+	retryReq = withRespondingNode(retryReq, next)
+	resp, err = next.Proxy.Transport.RoundTrip(retryReq)
+	if err != nil && failingNode(err, nil) == nil {
+		err = &roundTripError{node: next, err: err}
+	}
+	return resp, err
+}
+
+type rtResult struct {
+	resp *http.Response
+	err  error
+}
+
+// hedgedAttempt runs the primary request against t.node, and if hedgeAfter
+// elapses without a response, races a second request against a different
+// node, returning whichever finishes first and canceling the other.
+func (t *retryTransport) hedgedAttempt(base *http.Request, bodyBytes []byte) (*http.Response, error) {
+	primaryCtx, primaryCancel := context.WithCancel(base.Context())
+	// Only the losing attempt's context is canceled: the winner's response
+	// body is still read after this returns, and would fail with its context.
+	cancelPrimary, cancelHedge := false, false
+	defer func() {
+		if cancelPrimary {
+			primaryCancel()
+		}
+	}()
+	primaryReq := cloneRequestWithBody(base, primaryCtx, bodyBytes)
+	primaryReq = withRespondingNode(primaryReq, t.node)
+
+	primaryCh := make(chan rtResult, 1)
+	go func() {
+		resp, err := t.transport.RoundTrip(primaryReq)
+		if err != nil {
+			err = &roundTripError{node: t.node, err: err}
+		}
+		primaryCh <- rtResult{resp, err}
+	}()
+
+	if *hedgeAfter <= 0 {
+		r := <-primaryCh
+		return r.resp, r.err
+	}
+
+	select {
+	case r := <-primaryCh:
+		return r.resp, r.err
+	case <-time.After(*hedgeAfter):
+	}
+
+	hedgeNode := pickDifferentNode(t.node)
+	if hedgeNode == nil {
+		r := <-primaryCh
+		return r.resp, r.err
+	}
+
+	logger.Debugf("hedging request to node %s after %s", hedgeNode.URL, *hedgeAfter)
+
+	hedgeCtx, hedgeCancel := context.WithCancel(base.Context())
+	defer func() {
+		if cancelHedge {
+			hedgeCancel()
+		}
+	}()
+	hedgeReq := cloneRequestWithBody(base, hedgeCtx, bodyBytes)
+	retargetNode(hedgeReq, hedgeNode)
+	hedgeReq = withRespondingNode(hedgeReq, hedgeNode)
+
+	hedgeCh := make(chan rtResult, 1)
+	go func() {
+		resp, err := hedgeNode.Proxy.Transport.RoundTrip(hedgeReq)
+		if err != nil && failingNode(err, nil) == nil {
+			err = &roundTripError{node: hedgeNode, err: err}
+		}
+		hedgeCh <- rtResult{resp, err}
+	}()
+
+	select {
+	case r := <-primaryCh:
+		cancelHedge = true
+		go func() { drainResponse((<-hedgeCh).resp) }()
+		return r.resp, r.err
+	case r := <-hedgeCh:
+		cancelPrimary = true
+		go func() { drainResponse((<-primaryCh).resp) }()
+		return r.resp, r.err
+	}
+}
+
+func cloneRequestWithBody(req *http.Request, ctx context.Context, bodyBytes []byte) *http.Request {
+	clone := req.Clone(ctx)
+	if bodyBytes != nil {
+		clone.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		clone.ContentLength = int64(len(bodyBytes))
+	}
+	return clone
+}
+
+// pickDifferentNode asks nodeSelector for a node other than exclude.
+func pickDifferentNode(exclude *ReverseProxyNode) *ReverseProxyNode {
+	return nodeSelector.NextExcluding(nil, exclude)
+}
+
+func drainResponse(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if resp == nil {
+		return true
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return responseHasJSONRPCError(resp)
+}
+
+// responseHasJSONRPCError peeks at the body for the same `error` field that
+// node.ModifyResponse later checks, restoring the body so downstream readers
+// (ModifyResponse, callers of RoundTrip) still see the full response.
+func responseHasJSONRPCError(resp *http.Response) bool {
+	if resp.Body == nil {
+		return false
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		return false
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	ub, err := tryDecompressResponse(resp, b)
+	if err != nil {
+		return false
+	}
+	doc, err := jsonquery.Parse(bytes.NewReader(ub))
+	if err != nil {
+		return false
+	}
+	return jsonquery.FindOne(doc, "//error") != nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"github.com/spf13/pflag"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var reversePolicy = pflag.String("reverse.policy", "round-robin", "load balancing policy: round-robin, random, least-conn, least-latency, weighted, ip-hash")
+
+// Selector picks the next node a request should be sent to. Implementations
+// must skip nodes the health checker has marked unhealthy.
+type Selector interface {
+	Next(req *http.Request) *ReverseProxyNode
+
+	// NextExcluding is Next, but never returns exclude. Callers that want a
+	// *different* node than one that just failed (retry, hedge, ws
+	// reconnect) must use this instead of comparing Next's result against
+	// exclude in a loop: policies like ip-hash/least-conn/least-latency are
+	// pure functions of state rather than request-varying, so a loop of
+	// plain Next calls can return the same excluded node every time even
+	// though other healthy candidates exist.
+	NextExcluding(req *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode
+}
+
+func buildSelector(policy string, nodes []*ReverseProxyNode) Selector {
+	switch policy {
+	case "random":
+		return &randomSelector{nodes: nodes}
+	case "least-conn":
+		return &leastConnSelector{nodes: nodes}
+	case "least-latency":
+		return &leastLatencySelector{nodes: nodes}
+	case "weighted":
+		return &weightedSelector{nodes: nodes}
+	case "ip-hash":
+		return &ipHashSelector{nodes: nodes}
+	case "round-robin":
+		return &roundRobinSelector{nodes: nodes}
+	default:
+		logger.Fatalf("unknown reverse.policy: %s", policy)
+		return nil
+	}
+}
+
+// healthyNodes returns the nodes currently considered up, falling back to
+// every node if none are healthy so the proxy keeps serving traffic. A node
+// is skipped if the health checker ejected it or its circuit breaker's
+// allow() refuses it (open for less than breakerOpenDuration, or half-open).
+func healthyNodes(nodes []*ReverseProxyNode) []*ReverseProxyNode {
+	healthy := make([]*ReverseProxyNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Healthy.Load() && node.Breaker.allow() {
+			healthy = append(healthy, node)
+		}
+	}
+	if len(healthy) == 0 {
+		return nodes
+	}
+	return healthy
+}
+
+// removeNode returns nodes without exclude, preserving order. A nil exclude
+// (no node to avoid) returns nodes unchanged.
+func removeNode(nodes []*ReverseProxyNode, exclude *ReverseProxyNode) []*ReverseProxyNode {
+	if exclude == nil {
+		return nodes
+	}
+	out := make([]*ReverseProxyNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node != exclude {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// admitSelected finalizes a Selector's pick out of candidates, spending a
+// node's circuit breaker one-shot open->half-open trial only on the node
+// actually being returned — never merely on nodes considered while building
+// the candidate list. If a concurrent selection already claimed that trial
+// on the chosen node, it retries pick against the remaining candidates
+// instead of returning a node that would just be rejected downstream. If
+// every candidate loses that race, it falls back to the first pick so
+// Selector.Next still always returns a node.
+func admitSelected(candidates []*ReverseProxyNode, pick func([]*ReverseProxyNode) *ReverseProxyNode) *ReverseProxyNode {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	first := pick(candidates)
+	node := first
+	for remaining := candidates; ; {
+		if node.Breaker.admit() {
+			return node
+		}
+		remaining = removeNode(remaining, node)
+		if len(remaining) == 0 {
+			break
+		}
+		node = pick(remaining)
+	}
+	return first
+}
+
+// parseNodeWeight splits the optional `@weight` suffix used by the weighted
+// policy off of a --reverse.nodes entry, e.g. "https://x@3" -> ("https://x", 3).
+func parseNodeWeight(raw string) (string, uint32) {
+	idx := strings.LastIndex(raw, "@")
+	if idx == -1 {
+		return raw, 1
+	}
+
+	weight, err := strconv.ParseUint(raw[idx+1:], 10, 32)
+	if err != nil {
+		return raw, 1
+	}
+	return raw[:idx], uint32(weight)
+}
+
+type roundRobinSelector struct {
+	nodes []*ReverseProxyNode
+	index atomic.Uint64
+}
+
+func (s *roundRobinSelector) Next(_ *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes))
+}
+
+func (s *roundRobinSelector) NextExcluding(_ *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude))
+}
+
+func (s *roundRobinSelector) pick(candidates []*ReverseProxyNode) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		nextIndex := s.index.Add(1) % uint64(len(c))
+		logger.Debugf("round robin: next %d", nextIndex)
+		return c[nextIndex]
+	})
+}
+
+type randomSelector struct {
+	nodes []*ReverseProxyNode
+}
+
+func (s *randomSelector) Next(_ *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes))
+}
+
+func (s *randomSelector) NextExcluding(_ *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude))
+}
+
+func (s *randomSelector) pick(candidates []*ReverseProxyNode) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		return c[rand.Intn(len(c))]
+	})
+}
+
+// leastConnSelector routes to the node with the fewest in-flight requests,
+// tracked by instrumentedTransport.
+type leastConnSelector struct {
+	nodes []*ReverseProxyNode
+}
+
+func (s *leastConnSelector) Next(_ *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes))
+}
+
+func (s *leastConnSelector) NextExcluding(_ *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude))
+}
+
+func (s *leastConnSelector) pick(candidates []*ReverseProxyNode) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		best := c[0]
+		for _, node := range c[1:] {
+			if node.InFlight.Load() < best.InFlight.Load() {
+				best = node
+			}
+		}
+		return best
+	})
+}
+
+// leastLatencySelector routes to the node with the lowest response-time
+// EWMA, tracked by instrumentedTransport.
+type leastLatencySelector struct {
+	nodes []*ReverseProxyNode
+}
+
+func (s *leastLatencySelector) Next(_ *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes))
+}
+
+func (s *leastLatencySelector) NextExcluding(_ *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude))
+}
+
+func (s *leastLatencySelector) pick(candidates []*ReverseProxyNode) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		best := c[0]
+		for _, node := range c[1:] {
+			// an untested node (zero latency) is preferred over a measured
+			// one so every node gets a chance to report a real latency
+			// sample.
+			if best.Latency() != 0 && (node.Latency() == 0 || node.Latency() < best.Latency()) {
+				best = node
+			}
+		}
+		return best
+	})
+}
+
+// weightedSelector picks a node with probability proportional to its
+// Weight, set via the "@weight" suffix on --reverse.nodes entries.
+type weightedSelector struct {
+	nodes []*ReverseProxyNode
+}
+
+func (s *weightedSelector) Next(_ *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes))
+}
+
+func (s *weightedSelector) NextExcluding(_ *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude))
+}
+
+func (s *weightedSelector) pick(candidates []*ReverseProxyNode) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		var totalWeight uint32
+		for _, node := range c {
+			totalWeight += node.Weight
+		}
+		if totalWeight == 0 {
+			return c[rand.Intn(len(c))]
+		}
+
+		pick := uint32(rand.Intn(int(totalWeight)))
+		for _, node := range c {
+			if pick < node.Weight {
+				return node
+			}
+			pick -= node.Weight
+		}
+		return c[len(c)-1]
+	})
+}
+
+// ipHashSelector consistently hashes the client IP so the same client keeps
+// hitting the same upstream, useful for stateful subscriptions.
+type ipHashSelector struct {
+	nodes []*ReverseProxyNode
+}
+
+func (s *ipHashSelector) Next(req *http.Request) *ReverseProxyNode {
+	return s.pick(healthyNodes(s.nodes), req)
+}
+
+func (s *ipHashSelector) NextExcluding(req *http.Request, exclude *ReverseProxyNode) *ReverseProxyNode {
+	return s.pick(removeNode(healthyNodes(s.nodes), exclude), req)
+}
+
+func (s *ipHashSelector) pick(candidates []*ReverseProxyNode, req *http.Request) *ReverseProxyNode {
+	return admitSelected(candidates, func(c []*ReverseProxyNode) *ReverseProxyNode {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(clientIP(req)))
+		return c[h.Sum32()%uint32(len(c))]
+	})
+}
+
+func clientIP(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/pflag"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var wsNodesFlag = pflag.StringArray("reverse.ws-nodes", nil, "websocket-capable ethereum nodes (wss://...) to proxy eth_subscribe traffic to, enables /ws when set")
+
+var (
+	WSProxyNodes []*ReverseProxyNode
+	wsSelector   Selector
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// setupWSNodes parses --reverse.ws-nodes, reusing the same weight/tag
+// syntax and Selector machinery as the HTTP node list.
+func setupWSNodes() {
+	if len(*wsNodesFlag) == 0 {
+		return
+	}
+
+	WSProxyNodes = make([]*ReverseProxyNode, len(*wsNodesFlag))
+	for i, raw := range *wsNodesFlag {
+		nodeURL, weight, tags := parseNodeSpec(raw)
+		parsed, err := url.Parse(nodeURL)
+		if err != nil {
+			logger.Fatalf("Can't parse ws node url: %s", nodeURL)
+		}
+
+		node := &ReverseProxyNode{URL: parsed}
+		node.Healthy.Store(true)
+		node.Breaker.name = parsed.String()
+		metricNodeHealthy.WithLabelValues(parsed.String()).Set(1)
+		node.Weight = weight
+		node.Tags = tags
+		WSProxyNodes[i] = node
+	}
+
+	wsSelector = buildSelector(*reversePolicy, WSProxyNodes)
+}
+
+func dialWSNode(node *ReverseProxyNode) (*websocket.Conn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	conn, _, err := dialer.Dial(node.URL.String(), nil)
+	return conn, err
+}
+
+// wsSession tracks one client connection's subscriptions so that, if the
+// upstream it's pinned to drops, the proxy can silently reconnect to another
+// healthy node, replay the client's eth_subscribe calls, and remap the new
+// upstream subscription ids back to the ones the client already saw.
+type wsSession struct {
+	clientConn *websocket.Conn
+
+	mu                sync.Mutex
+	pendingSubscribes map[string][]byte // json-rpc request id -> raw eth_subscribe request
+	subscribeReqs     map[string][]byte // client-visible subscription id -> raw eth_subscribe request
+	idRemap           map[string]string // current upstream subscription id -> client-visible subscription id
+}
+
+func serveWS(writer http.ResponseWriter, request *http.Request) {
+	clientConn, err := wsUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		logger.Warnf("ws upgrade error: %s", err)
+		return
+	}
+	defer clientConn.Close()
+
+	node := wsSelector.Next(request)
+	upstream, err := dialWSNode(node)
+	if err != nil {
+		logger.Warnf("ws dial upstream %s error: %s", node.URL, err)
+		node.recordFailure()
+		return
+	}
+
+	sess := &wsSession{
+		clientConn:        clientConn,
+		pendingSubscribes: make(map[string][]byte),
+		subscribeReqs:     make(map[string][]byte),
+		idRemap:           make(map[string]string),
+	}
+	sess.pump(upstream, node)
+}
+
+func (sess *wsSession) pump(upstream *websocket.Conn, node *ReverseProxyNode) {
+	defer func() {
+		if upstream != nil {
+			upstream.Close()
+		}
+	}()
+
+	clientMsgs := make(chan []byte, 16)
+	go sess.readClient(clientMsgs)
+
+	upstreamMsgs := make(chan []byte, 16)
+	upstreamErrs := make(chan error, 1)
+	go readUpstream(upstream, upstreamMsgs, upstreamErrs)
+
+	for {
+		select {
+		case msg, ok := <-clientMsgs:
+			if !ok {
+				return
+			}
+			sess.trackSubscribeRequest(msg)
+			msg = sess.rewriteOutgoingUnsubscribe(msg)
+			if err := upstream.WriteMessage(websocket.TextMessage, msg); err != nil {
+				logger.Warnf("ws write to upstream %s error: %s", node.URL, err)
+			}
+
+		case msg := <-upstreamMsgs:
+			out := sess.processUpstreamMessage(msg)
+			if err := sess.clientConn.WriteMessage(websocket.TextMessage, out); err != nil {
+				return
+			}
+
+		case err := <-upstreamErrs:
+			logger.Warnf("ws upstream %s disconnected: %s, reconnecting", node.URL, err)
+			node.recordFailure()
+			upstream.Close()
+
+			newUpstream, newNode, err := sess.reconnect(node)
+			if err != nil {
+				logger.Warnf("ws reconnect failed for all nodes: %s", err)
+				return
+			}
+			upstream, node = newUpstream, newNode
+			upstreamMsgs = make(chan []byte, 16)
+			upstreamErrs = make(chan error, 1)
+			go readUpstream(upstream, upstreamMsgs, upstreamErrs)
+		}
+	}
+}
+
+// reconnect dials another healthy node other than exclude (the node that
+// just disconnected) and replays every subscription the client had open,
+// rebuilding idRemap from scratch.
+func (sess *wsSession) reconnect(exclude *ReverseProxyNode) (*websocket.Conn, *ReverseProxyNode, error) {
+	var lastErr error
+	lastTried := exclude
+	for attempt := 0; attempt < len(WSProxyNodes); attempt++ {
+		node := wsSelector.NextExcluding(nil, lastTried)
+		if node == nil {
+			break
+		}
+		lastTried = node
+		if node == exclude {
+			continue
+		}
+
+		conn, err := dialWSNode(node)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		sess.mu.Lock()
+		newIdRemap := make(map[string]string, len(sess.subscribeReqs))
+		for clientSubID, req := range sess.subscribeReqs {
+			if err := conn.WriteMessage(websocket.TextMessage, req); err != nil {
+				lastErr = err
+				continue
+			}
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			var resp jsonrpcResponse
+			if err := json.Unmarshal(msg, &resp); err != nil || resp.Result == nil {
+				continue
+			}
+			var newSubID string
+			if err := json.Unmarshal(resp.Result, &newSubID); err != nil {
+				continue
+			}
+			newIdRemap[newSubID] = clientSubID
+			logger.Debugf("ws resubscribed %s as %s on node %s", clientSubID, newSubID, node.URL)
+		}
+		sess.idRemap = newIdRemap
+		sess.mu.Unlock()
+
+		return conn, node, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no healthy ws node available")
+	}
+	return nil, nil, lastErr
+}
+
+// rewriteOutgoingUnsubscribe rewrites an eth_unsubscribe call's target id
+// from the client-visible subscription id to whatever id the currently
+// connected upstream actually assigned, since a reconnect renumbers
+// subscriptions upstream-side while the client keeps addressing the id it
+// originally received.
+func (sess *wsSession) rewriteOutgoingUnsubscribe(raw []byte) []byte {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.Method != "eth_unsubscribe" {
+		return raw
+	}
+
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return raw
+	}
+
+	sess.mu.Lock()
+	upstreamSubID, ok := sess.reverseIdRemapLocked(params[0])
+	sess.mu.Unlock()
+	if !ok || upstreamSubID == params[0] {
+		return raw
+	}
+
+	params[0] = upstreamSubID
+	newParams, err := json.Marshal(params)
+	if err != nil {
+		return raw
+	}
+	req.Params = newParams
+
+	remapped, err := json.Marshal(req)
+	if err != nil {
+		return raw
+	}
+	return remapped
+}
+
+// reverseIdRemapLocked looks up the upstream subscription id currently
+// mapped to clientSubID. Callers must hold sess.mu.
+func (sess *wsSession) reverseIdRemapLocked(clientSubID string) (string, bool) {
+	for upstreamSubID, id := range sess.idRemap {
+		if id == clientSubID {
+			return upstreamSubID, true
+		}
+	}
+	return "", false
+}
+
+func (sess *wsSession) trackSubscribeRequest(raw []byte) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.Method != "eth_subscribe" || len(req.ID) == 0 {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.pendingSubscribes[string(req.ID)] = append([]byte(nil), raw...)
+}
+
+// processUpstreamMessage records newly assigned subscription ids and
+// rewrites eth_subscription notifications so the client keeps seeing the
+// subscription id it originally received, even after a reconnect.
+func (sess *wsSession) processUpstreamMessage(raw []byte) []byte {
+	var resp jsonrpcResponse
+	if err := json.Unmarshal(raw, &resp); err == nil && len(resp.ID) > 0 && resp.Result != nil {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		if pending, ok := sess.pendingSubscribes[string(resp.ID)]; ok {
+			delete(sess.pendingSubscribes, string(resp.ID))
+			var subID string
+			if err := json.Unmarshal(resp.Result, &subID); err == nil {
+				sess.subscribeReqs[subID] = pending
+				sess.idRemap[subID] = subID
+			}
+		}
+		return raw
+	}
+
+	var notification struct {
+		JSONRPC string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &notification); err != nil || notification.Method != "eth_subscription" {
+		return raw
+	}
+
+	sess.mu.Lock()
+	clientSubID, ok := sess.idRemap[notification.Params.Subscription]
+	sess.mu.Unlock()
+	if !ok || clientSubID == notification.Params.Subscription {
+		return raw
+	}
+
+	notification.Params.Subscription = clientSubID
+	remapped, err := json.Marshal(notification)
+	if err != nil {
+		return raw
+	}
+	return remapped
+}
+
+func (sess *wsSession) readClient(out chan<- []byte) {
+	defer close(out)
+	for {
+		_, msg, err := sess.clientConn.ReadMessage()
+		if err != nil {
+			return
+		}
+		out <- msg
+	}
+}
+
+func readUpstream(conn *websocket.Conn, out chan<- []byte, errs chan<- error) {
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+		out <- msg
+	}
+}